@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/i5heu/telegram-json-forwarder/telegram"
+)
+
+var telegramAPI = telegram.NewAPI(TelegramBotToken)
+
+var TelegramWebhookSecret = os.Getenv("TELEGRAM_WEBHOOK_SECRET")
+
+// hookSecretMiddleware rejects requests to /webhook that don't present
+// the configured HOOK_SECRET, either as the trailing path segment
+// ("/webhook/<secret>") or via the X-Hook-Secret header. With no
+// HOOK_SECRET configured, the check is skipped entirely.
+func hookSecretMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if HookSecret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		secret := r.Header.Get("X-Hook-Secret")
+		if secret == "" {
+			secret = strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/webhook"), "/")
+		}
+
+		if secret != HookSecret {
+			http.Error(w, "Invalid hook secret", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// telegramSecretMiddleware rejects requests to /telegram-update that
+// don't present the X-Telegram-Bot-Api-Secret-Token header Telegram
+// echoes back when a secret_token was registered via
+// telegram.API.SetWebhook. Without this check any caller could forge an
+// Update (including its chat ID) and trigger bot commands or deliveries
+// to an arbitrary chat. With no TELEGRAM_WEBHOOK_SECRET configured, the
+// check is skipped, consistent with hookSecretMiddleware's behavior.
+func telegramSecretMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if TelegramWebhookSecret == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("X-Telegram-Bot-Api-Secret-Token") != TelegramWebhookSecret {
+			http.Error(w, "Invalid secret token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// newCommandRouter builds the CommandRouter backing /telegram-update,
+// registering the bot commands this deployment understands.
+func newCommandRouter() *telegram.CommandRouter {
+	router := telegram.NewCommandRouter()
+
+	router.Handle("/stats", handleStatsCommand)
+	router.Handle("/last", handleLastCommand)
+	router.Handle("/mute", handleMuteCommand)
+
+	return router
+}
+
+// forwarderState tracks the bits /stats, /last and /mute report on or
+// mutate, guarded by a mutex since it's read and written from concurrent
+// webhook and bot-command requests.
+var forwarderState = &struct {
+	mu       sync.Mutex
+	muted    bool
+	lastSeen string
+}{lastSeen: "no events received yet"}
+
+// isMuted reports whether webhookHandler should currently skip
+// forwarding events to the sinks.
+func isMuted() bool {
+	forwarderState.mu.Lock()
+	defer forwarderState.mu.Unlock()
+	return forwarderState.muted
+}
+
+// recordEventSummary updates what /last reports, called once per
+// received webhook event.
+func recordEventSummary(event Event) {
+	forwarderState.mu.Lock()
+	defer forwarderState.mu.Unlock()
+	forwarderState.lastSeen = fmt.Sprintf("Last event: type=%s at %s", event.Type, time.Now().Format(time.RFC3339))
+}
+
+func handleStatsCommand(msg *telegram.Message, args []string) error {
+	status := "active"
+	if isMuted() {
+		status = "muted"
+	}
+	return telegramAPI.SendMessage(msg.Chat.ID, 0, fmt.Sprintf("Forwarder is %s.", status), "Markdown")
+}
+
+func handleLastCommand(msg *telegram.Message, args []string) error {
+	forwarderState.mu.Lock()
+	summary := forwarderState.lastSeen
+	forwarderState.mu.Unlock()
+	return telegramAPI.SendMessage(msg.Chat.ID, 0, summary, "Markdown")
+}
+
+func handleMuteCommand(msg *telegram.Message, args []string) error {
+	forwarderState.mu.Lock()
+	forwarderState.muted = !forwarderState.muted
+	muted := forwarderState.muted
+	forwarderState.mu.Unlock()
+
+	state := "unmuted"
+	if muted {
+		state = "muted"
+	}
+	return telegramAPI.SendMessage(msg.Chat.ID, 0, fmt.Sprintf("Forwarder is now %s.", state), "Markdown")
+}
+
+// telegramUpdateHandler accepts Telegram Bot API Update payloads and
+// dispatches them to router. Unknown commands and non-command updates are
+// acknowledged without error, as Telegram expects a 200 regardless.
+func telegramUpdateHandler(router *telegram.CommandRouter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Could not read request body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		var update telegram.Update
+		if err := json.Unmarshal(body, &update); err != nil {
+			http.Error(w, "Could not parse JSON", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := router.Dispatch(&update); err != nil {
+			log.Printf("Error handling Telegram command: %s\n", err.Error())
+		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "OK")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import "fmt"
+
+// Event is a decoded webhook payload. Raw keeps the original fields for
+// formatters that don't need a typed view, while Timing is populated (and
+// validated) for the "navigation_timing" event type.
+type Event struct {
+	Type   string                 `json:"type"`
+	Raw    map[string]interface{} `json:"raw"`
+	Timing *TimingData            `json:"timing,omitempty"`
+}
+
+// TimingData is the validated subset of the W3C Navigation Timing API
+// fields this project renders as a waterfall.
+type TimingData struct {
+	NavigationStart          float64
+	RedirectStart            float64
+	RedirectEnd              float64
+	FetchStart               float64
+	DomainLookupStart        float64
+	DomainLookupEnd          float64
+	ConnectStart             float64
+	ConnectEnd               float64
+	SecureConnectionStart    float64
+	RequestStart             float64
+	ResponseStart            float64
+	ResponseEnd              float64
+	DomLoading               float64
+	DomComplete              float64
+	DomContentLoadedEventEnd float64
+	LoadEventStart           float64
+	LoadEventEnd             float64
+}
+
+// requiredTimingFields lists the navigationTiming keys decodeTimingData
+// needs; anything missing or non-numeric is reported back to the client
+// as HTTP 400 instead of crashing the server on a nil type assertion.
+var requiredTimingFields = []string{
+	"navigationStart", "redirectStart", "redirectEnd", "fetchStart",
+	"domainLookupStart", "domainLookupEnd", "connectStart", "connectEnd",
+	"secureConnectionStart", "requestStart", "responseStart", "responseEnd",
+	"domLoading", "domComplete", "domContentLoadedEventEnd",
+	"loadEventStart", "loadEventEnd",
+}
+
+// decodeEvent validates raw into an Event, determining its type from the
+// "type" field (defaulting to "custom") and, for "navigation_timing"
+// events, validating the nested "timing" object up front.
+func decodeEvent(raw map[string]interface{}) (Event, error) {
+	eventType, _ := raw["type"].(string)
+	if eventType == "" {
+		eventType = "custom"
+	}
+
+	event := Event{Type: eventType, Raw: raw}
+
+	if eventType == "navigation_timing" {
+		timingRaw, ok := raw["timing"].(map[string]interface{})
+		if !ok {
+			return Event{}, fmt.Errorf(`navigation_timing event requires a "timing" object`)
+		}
+
+		timing, err := decodeTimingData(timingRaw)
+		if err != nil {
+			return Event{}, err
+		}
+		event.Timing = timing
+	}
+
+	return event, nil
+}
+
+func decodeTimingData(raw map[string]interface{}) (*TimingData, error) {
+	values := make(map[string]float64, len(requiredTimingFields))
+	for _, field := range requiredTimingFields {
+		value, ok := raw[field]
+		if !ok {
+			return nil, fmt.Errorf("timing data missing required field %q", field)
+		}
+		num, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("timing field %q must be a number", field)
+		}
+		values[field] = num
+	}
+
+	return &TimingData{
+		NavigationStart:          values["navigationStart"],
+		RedirectStart:            values["redirectStart"],
+		RedirectEnd:              values["redirectEnd"],
+		FetchStart:               values["fetchStart"],
+		DomainLookupStart:        values["domainLookupStart"],
+		DomainLookupEnd:          values["domainLookupEnd"],
+		ConnectStart:             values["connectStart"],
+		ConnectEnd:               values["connectEnd"],
+		SecureConnectionStart:    values["secureConnectionStart"],
+		RequestStart:             values["requestStart"],
+		ResponseStart:            values["responseStart"],
+		ResponseEnd:              values["responseEnd"],
+		DomLoading:               values["domLoading"],
+		DomComplete:              values["domComplete"],
+		DomContentLoadedEventEnd: values["domContentLoadedEventEnd"],
+		LoadEventStart:           values["loadEventStart"],
+		LoadEventEnd:             values["loadEventEnd"],
+	}, nil
+}
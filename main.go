@@ -8,19 +8,29 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"time"
 )
 
 var TelegramBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
 var TelegramChatID = os.Getenv("TELEGRAM_CHAT_ID")
 var AllowedCORSOrigin = os.Getenv("ALLOWED_CORS_ORIGIN")
+var HookSecret = os.Getenv("HOOK_SECRET")
+
+var retryQueueInstance = newRetryQueue(QueuePath)
 
 func main() {
 	if TelegramBotToken == "" || TelegramChatID == "" {
 		log.Fatal("TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID must be set as environment variables")
 	}
 
-	http.HandleFunc("/webhook", corsMiddleware(webhookHandler))
+	limiter := newRateLimiter(RateLimit)
+	router := newCommandRouter()
+
+	webhook := corsMiddleware(rateLimitMiddleware(limiter, hookSecretMiddleware(webhookHandler)))
+	http.HandleFunc("/webhook", webhook)
+	http.HandleFunc("/webhook/", webhook)
+	http.HandleFunc("/telegram-update", corsMiddleware(telegramSecretMiddleware(telegramUpdateHandler(router))))
+	http.HandleFunc("/queue/status", corsMiddleware(queueStatusHandler))
 	http.HandleFunc("/", corsMiddleware(ok))
 
 	log.Println("Starting server on :80")
@@ -65,14 +75,50 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	var data map[string]interface{}
-	if err := json.Unmarshal(body, &data); err != nil {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(body, &raw); err != nil {
 		http.Error(w, "Could not parse JSON", http.StatusBadRequest)
 		return
 	}
 
-	if err := sendToTelegram(data); err != nil {
-		log.Printf("Error sending message to Telegram: %s\n", err.Error())
+	event, err := decodeEvent(raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recordEventSummary(event)
+
+	if isMuted() {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Muted")
+		return
+	}
+
+	sinks := sinksForEvent(event)
+
+	pending, failed, err := dispatchToSinks(r.Context(), sinks, event)
+	if err != nil {
+		log.Printf("Error dispatching event to sinks: %s\n", err.Error())
+	}
+
+	if len(pending) > 0 {
+		if retryQueueInstance == nil {
+			http.Error(w, "Error", http.StatusInternalServerError)
+			return
+		}
+
+		retryQueueInstance.enqueue(event, pending, backoffFor(1, err))
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "Queued for retry")
+		return
+	}
+
+	// Nothing left to retry. If every configured sink failed (all of them
+	// permanently, since nothing came back pending), nothing was actually
+	// delivered; otherwise at least one sink succeeded and any failures
+	// were already logged above.
+	if len(sinks) > 0 && failed == len(sinks) {
 		http.Error(w, "Error", http.StatusInternalServerError)
 		return
 	}
@@ -81,60 +127,37 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "OK")
 }
 
-func formatTimingData(timingData map[string]interface{}) string {
-	// Start from navigationStart
-	navigationStart := timingData["navigationStart"].(float64)
-
-	calculatedTimes := map[string]float64{
-		"Redirect":          timingData["redirectEnd"].(float64) - timingData["redirectStart"].(float64),
-		"AppCache":          timingData["domainLookupStart"].(float64) - timingData["fetchStart"].(float64),
-		"DNS Lookup":        timingData["domainLookupEnd"].(float64) - timingData["domainLookupStart"].(float64),
-		"TCP Connection":    timingData["connectEnd"].(float64) - timingData["connectStart"].(float64),
-		"SSL Handshake":     timingData["connectEnd"].(float64) - timingData["secureConnectionStart"].(float64),
-		"Request Sent":      timingData["responseStart"].(float64) - timingData["requestStart"].(float64),
-		"Response Received": timingData["responseEnd"].(float64) - timingData["responseStart"].(float64),
-		"DOM Processing":    timingData["domComplete"].(float64) - timingData["domLoading"].(float64),
-		"Load Event":        timingData["loadEventEnd"].(float64) - timingData["loadEventStart"].(float64),
-		"DOMContentLoaded":  timingData["domContentLoadedEventEnd"].(float64) - navigationStart,
-		"Finish":            timingData["loadEventEnd"].(float64) - navigationStart,
-	}
-
-	// Create a waterfall-like output
-	waterfall := "*Waterfall Timing (in ms):*\n"
-	for key, value := range calculatedTimes {
-		if value > 0 {
-			waterfall += fmt.Sprintf("*%s:* %.2f ms\n", key, value)
-		}
+// queueStatusHandler reports how many deliveries are pending retry and
+// the age of the oldest one, for /queue/status.
+func queueStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if retryQueueInstance == nil {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"pending":0,"oldest_age_seconds":0}`)
+		return
 	}
 
-	// Final DOM loading time from navigation start to domLoading
-	domLoadingTime := timingData["domLoading"].(float64) - navigationStart
-	waterfall += fmt.Sprintf("\n*DOM Loading Time:* %.2f ms\n", domLoadingTime)
+	pending, oldestAge := retryQueueInstance.status()
 
-	return waterfall
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pending":            pending,
+		"oldest_age_seconds": oldestAge.Seconds(),
+	})
 }
 
-func sendToTelegram(data map[string]interface{}) error {
-	var message strings.Builder
-	message.WriteString("*Received message:*\n\n")
-
-	for key, value := range data {
-		switch key {
-		case "timing":
-			if timingMap, ok := value.(map[string]interface{}); ok {
-				message.WriteString(formatTimingData(timingMap))
-			}
-		default:
-			message.WriteString(fmt.Sprintf("*%s:* %v\n", key, value))
-		}
-	}
-
+// postTelegramMessage calls Telegram's sendMessage directly (rather than
+// through the telegram.API client) so a non-200 response can be turned
+// into a telegramAPIError carrying a parsed retry_after.
+func postTelegramMessage(chatID string, threadID int, text, parseMode string) error {
 	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", TelegramBotToken)
 
-	payload := map[string]string{
-		"chat_id":    TelegramChatID,
-		"text":       message.String(),
-		"parse_mode": "Markdown",
+	payload := map[string]interface{}{
+		"chat_id":    chatID,
+		"text":       text,
+		"parse_mode": parseMode,
+	}
+	if threadID != 0 {
+		payload["message_thread_id"] = threadID
 	}
 
 	payloadBytes, err := json.Marshal(payload)
@@ -149,8 +172,42 @@ func sendToTelegram(data map[string]interface{}) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send message to Telegram, status code: %d", resp.StatusCode)
+		return telegramAPIError(resp)
 	}
 
 	return nil
 }
+
+// telegramAPIErrorBody is the shape of a Telegram Bot API error response,
+// used to extract the retry_after hint Telegram sends on HTTP 429.
+type telegramAPIErrorBody struct {
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// telegramAPIError builds the error returned for a non-200 Telegram
+// response. A 429 is wrapped in a retryAfterError when the response
+// carries a retry_after hint so the retry queue can honor it; other 4xx
+// responses (bad chat ID, malformed request, ...) depend only on the
+// message or destination and won't succeed on retry, so they're wrapped
+// in a permanentError instead. 5xx and anything else is left as a plain,
+// retryable error.
+func telegramAPIError(resp *http.Response) error {
+	baseErr := fmt.Errorf("failed to send message to Telegram, status code: %d", resp.StatusCode)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var body telegramAPIErrorBody
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Parameters.RetryAfter > 0 {
+			return &retryAfterError{err: baseErr, RetryAfter: time.Duration(body.Parameters.RetryAfter) * time.Second}
+		}
+		return baseErr
+	}
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &permanentError{err: baseErr}
+	}
+
+	return baseErr
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var QueuePath = os.Getenv("QUEUE_PATH")
+
+const (
+	retryBaseDelay = 1 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// queueEntry is a single pending delivery persisted to QUEUE_PATH as one
+// JSON object per line. The whole file is rewritten to a temp file and
+// renamed into place on every change (see persist), so a crash mid-write
+// never loses or truncates the entries that were already durable.
+//
+// PendingKeys holds the namedSink keys (see sinksForEvent) that still need
+// Event delivered to them. Only those sinks are redelivered to on retry,
+// so a destination that's already succeeded never receives a duplicate,
+// and PendingKeys shrinks as destinations succeed or fail permanently.
+type queueEntry struct {
+	ID          int64     `json:"id"`
+	Event       Event     `json:"event"`
+	PendingKeys []string  `json:"pending_keys"`
+	Attempts    int       `json:"attempts"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// retryQueue is a durable queue of failed sink deliveries, redelivered by
+// a background worker with exponential backoff.
+type retryQueue struct {
+	mu      sync.Mutex
+	path    string
+	nextID  int64
+	entries []*queueEntry
+}
+
+// newRetryQueue loads any entries left over from a previous run at path.
+// An empty path disables the queue; failed deliveries are then dropped as
+// before.
+func newRetryQueue(path string) *retryQueue {
+	if path == "" {
+		return nil
+	}
+
+	q := &retryQueue{path: path}
+	q.load()
+
+	go q.worker()
+
+	return q
+}
+
+func (q *retryQueue) load() {
+	f, err := os.Open(q.path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry queueEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		q.entries = append(q.entries, &entry)
+		if entry.ID >= q.nextID {
+			q.nextID = entry.ID + 1
+		}
+	}
+}
+
+// persist rewrites the queue file from the in-memory entries. It's only
+// called while holding q.mu, and the queue is expected to stay small since
+// entries are cleared as soon as a delivery succeeds. It writes to a temp
+// file and renames it into place so a crash mid-write never leaves q.path
+// itself truncated or half-written.
+func (q *retryQueue) persist() error {
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	enc := json.NewEncoder(tmp)
+	for _, entry := range q.entries {
+		if err := enc.Encode(entry); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, q.path)
+}
+
+// enqueue adds event as a pending delivery to the destinations identified
+// by pendingKeys, retried after delay.
+func (q *retryQueue) enqueue(event Event, pendingKeys []string, delay time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry := &queueEntry{
+		ID:          q.nextID,
+		Event:       event,
+		PendingKeys: pendingKeys,
+		EnqueuedAt:  time.Now(),
+		NextAttempt: time.Now().Add(delay),
+	}
+	q.nextID++
+	q.entries = append(q.entries, entry)
+
+	if err := q.persist(); err != nil {
+		log.Printf("Error persisting retry queue: %s\n", err.Error())
+	}
+}
+
+// status reports the number of pending entries and the age of the oldest
+// one, for the /queue/status endpoint.
+func (q *retryQueue) status() (pending int, oldestAge time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending = len(q.entries)
+	if pending == 0 {
+		return 0, 0
+	}
+
+	oldest := q.entries[0].EnqueuedAt
+	for _, entry := range q.entries[1:] {
+		if entry.EnqueuedAt.Before(oldest) {
+			oldest = entry.EnqueuedAt
+		}
+	}
+
+	return pending, time.Since(oldest)
+}
+
+// worker redelivers due entries, backing off exponentially (capped at
+// retryMaxDelay) or honoring a sink-reported Retry-After when present.
+func (q *retryQueue) worker() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		q.runDue()
+	}
+}
+
+func (q *retryQueue) runDue() {
+	q.mu.Lock()
+	var due []*queueEntry
+	var remaining []*queueEntry
+	snapshotLen := len(q.entries)
+	now := time.Now()
+	for _, entry := range q.entries {
+		if now.After(entry.NextAttempt) || now.Equal(entry.NextAttempt) {
+			due = append(due, entry)
+		} else {
+			remaining = append(remaining, entry)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, entry := range due {
+		sinks := filterSinksByKey(sinksForEvent(entry.Event), entry.PendingKeys)
+
+		pending, _, err := dispatchToSinks(context.Background(), sinks, entry.Event)
+		if len(pending) == 0 {
+			if err != nil {
+				log.Printf("Retry queue delivery %d finished with permanent failures, not re-queuing: %s\n", entry.ID, err.Error())
+			}
+			continue
+		}
+
+		entry.PendingKeys = pending
+		entry.Attempts++
+		entry.NextAttempt = time.Now().Add(backoffFor(entry.Attempts, err))
+		remaining = append(remaining, entry)
+		log.Printf("Retry queue delivery %d failed (attempt %d): %s\n", entry.ID, entry.Attempts, err.Error())
+	}
+
+	q.mu.Lock()
+	// Keep anything enqueued while the due entries were being dispatched.
+	q.entries = append(remaining, q.entries[snapshotLen:]...)
+	if err := q.persist(); err != nil {
+		log.Printf("Error persisting retry queue: %s\n", err.Error())
+	}
+	q.mu.Unlock()
+}
+
+// backoffFor computes the next retry delay: the smallest retry_after
+// Telegram reported among err's failures (err may be an errors.Join tree
+// aggregating several sink/target errors), otherwise exponential backoff
+// doubling from retryBaseDelay up to retryMaxDelay.
+func backoffFor(attempts int, err error) time.Duration {
+	if retryAfters := collectRetryAfters(err); len(retryAfters) > 0 {
+		delay := retryAfters[0]
+		for _, d := range retryAfters[1:] {
+			delay = min(delay, d)
+		}
+		return delay
+	}
+
+	delay := retryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+
+	return delay
+}
+
+// retryAfterError is returned by sinks that learned a specific retry
+// delay from the remote service, e.g. Telegram's 429 retry_after.
+type retryAfterError struct {
+	err        error
+	RetryAfter time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", e.err.Error(), e.RetryAfter)
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// permanentError marks a sink failure that retrying can't fix, such as
+// bad credentials or a malformed destination. dispatchToSinks logs and
+// drops these instead of handing them back as pending, so the retry queue
+// never keeps redelivering to a destination that can only ever fail.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isPermanent reports whether err (or anything in its wrap tree) is a
+// *permanentError.
+func isPermanent(err error) bool {
+	var permErr *permanentError
+	return errors.As(err, &permErr)
+}
+
+// collectRetryAfters walks err's wrap tree (including errors.Join's
+// multi-error Unwrap() []error form, as produced by dispatchToSinks) and
+// returns the RetryAfter of every *retryAfterError found.
+func collectRetryAfters(err error) []time.Duration {
+	var found []time.Duration
+
+	var walk func(error)
+	walk = func(e error) {
+		if e == nil {
+			return
+		}
+
+		if rlErr, ok := e.(*retryAfterError); ok {
+			found = append(found, rlErr.RetryAfter)
+		}
+
+		switch unwrapped := e.(type) {
+		case interface{ Unwrap() []error }:
+			for _, sub := range unwrapped.Unwrap() {
+				walk(sub)
+			}
+		case interface{ Unwrap() error }:
+			walk(unwrapped.Unwrap())
+		}
+	}
+
+	walk(err)
+	return found
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var RateLimit = os.Getenv("RATE_LIMIT")
+var RateLimitTrustProxy = os.Getenv("RATE_LIMIT_TRUST_PROXY") == "true"
+
+// rateLimiter is a simple in-memory token bucket store keyed by client IP.
+// Each bucket holds up to limit tokens and refills gradually at
+// limit/window tokens per second, rather than resetting to a full bucket
+// at a fixed instant, so a client can't burst up to 2x limit by timing
+// requests either side of a window boundary.
+type rateLimiter struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	limit       int
+	window      time.Duration
+	refillPerNs float64
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// newRateLimiter parses a "<count>-<unit>" spec such as "10-M" (10 per
+// minute) or "5-S" (5 per second). An empty spec disables rate limiting.
+func newRateLimiter(spec string) *rateLimiter {
+	if spec == "" {
+		return nil
+	}
+
+	limit, window, err := parseRateLimitSpec(spec)
+	if err != nil {
+		log.Printf("Invalid RATE_LIMIT %q, rate limiting disabled: %s\n", spec, err.Error())
+		return nil
+	}
+
+	rl := &rateLimiter{
+		buckets:     make(map[string]*tokenBucket),
+		limit:       limit,
+		window:      window,
+		refillPerNs: float64(limit) / float64(window),
+	}
+
+	go rl.gcLoop()
+
+	return rl
+}
+
+func parseRateLimitSpec(spec string) (int, time.Duration, error) {
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected format <count>-<unit>, e.g. 10-M")
+	}
+
+	count, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid count: %w", err)
+	}
+
+	var window time.Duration
+	switch strings.ToUpper(parts[1]) {
+	case "S":
+		window = time.Second
+	case "M":
+		window = time.Minute
+	case "H":
+		window = time.Hour
+	default:
+		return 0, 0, fmt.Errorf("unknown unit %q, expected S, M or H", parts[1])
+	}
+
+	return count, window, nil
+}
+
+// allow reports whether the given IP is still within its quota, consuming
+// a token from its bucket if so. Buckets refill gradually, so the tokens
+// available are computed from how long it's been since the bucket was
+// last touched rather than from a stored "remaining" count.
+func (rl *rateLimiter) allow(ip string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := rl.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rl.limit), lastRefill: now}
+		rl.buckets[ip] = bucket
+	} else {
+		elapsed := now.Sub(bucket.lastRefill)
+		bucket.tokens = min(float64(rl.limit), bucket.tokens+float64(elapsed)*rl.refillPerNs)
+		bucket.lastRefill = now
+	}
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / rl.refillPerNs)
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// gcLoop periodically drops buckets that have expired so the map doesn't
+// grow unbounded under a churn of distinct client IPs.
+func (rl *rateLimiter) gcLoop() {
+	ticker := time.NewTicker(rl.window)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		rl.mu.Lock()
+		now := time.Now()
+		for ip, bucket := range rl.buckets {
+			// A bucket untouched for a full window has long since
+			// refilled to capacity, so it's safe to drop: a new bucket
+			// starts full too.
+			if now.Sub(bucket.lastRefill) > rl.window {
+				delete(rl.buckets, ip)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// clientIP extracts the request's client IP, honoring X-Forwarded-For
+// only when RATE_LIMIT_TRUST_PROXY is enabled.
+func clientIP(r *http.Request) string {
+	if RateLimitTrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			parts := strings.Split(fwd, ",")
+			return strings.TrimSpace(parts[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitMiddleware rejects requests over the configured quota with
+// HTTP 429 before they ever reach the sinks. A nil limiter (no RATE_LIMIT
+// configured) disables the check entirely.
+func rateLimitMiddleware(limiter *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := clientIP(r)
+		allowed, retryAfter := limiter.allow(ip)
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
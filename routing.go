@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var RoutesConfigPath = os.Getenv("ROUTES_CONFIG")
+
+// ChatTarget is a single Telegram destination: a chat (numeric ID or
+// @channelname), an optional forum topic thread, and the parse mode to
+// send with.
+type ChatTarget struct {
+	ChatID    string `json:"chat_id"`
+	ThreadID  int    `json:"thread_id,omitempty"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+// Route picks which targets receive an event. TagGlob matches against the
+// event's "tag" field with shell-style globbing; FieldRegex additionally
+// requires named fields to match a regex. A route with neither matches
+// every event. An empty Targets list is valid and suppresses delivery for
+// events the route matches.
+type Route struct {
+	TagGlob    string            `json:"tag_glob,omitempty"`
+	FieldRegex map[string]string `json:"field_regex,omitempty"`
+	Targets    []ChatTarget      `json:"targets"`
+}
+
+// compiledRoute is a Route with its field regexes precompiled once at
+// load time rather than on every incoming event.
+type compiledRoute struct {
+	tagGlob    string
+	fieldRegex map[string]*regexp.Regexp
+	targets    []ChatTarget
+}
+
+// defaultChatTargets is parsed once from TELEGRAM_CHAT_ID, used when no
+// ROUTES_CONFIG is set or no route matches an event.
+var defaultChatTargets = parseChatList(TelegramChatID)
+
+// routes is the set of routing rules loaded once from ROUTES_CONFIG, if
+// any.
+var routes = loadRoutes(RoutesConfigPath)
+
+// parseChatList parses a comma-separated TELEGRAM_CHAT_ID spec such as
+// "chat1,chat2:123,@channelname" into ChatTargets, where ":123" selects a
+// forum topic thread.
+func parseChatList(spec string) []ChatTarget {
+	var targets []ChatTarget
+	for _, raw := range strings.Split(spec, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		chatID, threadPart, hasThread := strings.Cut(raw, ":")
+		target := ChatTarget{ChatID: chatID}
+		if hasThread {
+			if threadID, err := strconv.Atoi(threadPart); err == nil {
+				target.ThreadID = threadID
+			}
+		}
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+func loadRoutes(path string) []compiledRoute {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Error reading ROUTES_CONFIG %q: %s\n", path, err.Error())
+		return nil
+	}
+
+	var parsed []Route
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		log.Printf("Error parsing ROUTES_CONFIG %q: %s\n", path, err.Error())
+		return nil
+	}
+
+	compiled := make([]compiledRoute, 0, len(parsed))
+	for _, route := range parsed {
+		fieldRegex := make(map[string]*regexp.Regexp, len(route.FieldRegex))
+		for field, pattern := range route.FieldRegex {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				log.Printf("Error compiling field_regex %q for field %q in ROUTES_CONFIG %q: %s\n", pattern, field, path, err.Error())
+				continue
+			}
+			fieldRegex[field] = re
+		}
+
+		compiled = append(compiled, compiledRoute{
+			tagGlob:    route.TagGlob,
+			fieldRegex: fieldRegex,
+			targets:    route.Targets,
+		})
+	}
+
+	return compiled
+}
+
+// targetsForEvent returns the ChatTargets that should receive event. It
+// falls back to defaultChatTargets only when no route matched at all; a
+// route that matched but declares no targets deliberately suppresses
+// delivery.
+func targetsForEvent(event Event) []ChatTarget {
+	var matched []ChatTarget
+	matchedAny := false
+
+	for _, route := range routes {
+		if routeMatches(route, event) {
+			matchedAny = true
+			matched = append(matched, route.targets...)
+		}
+	}
+
+	if !matchedAny {
+		return defaultChatTargets
+	}
+
+	return matched
+}
+
+func routeMatches(route compiledRoute, event Event) bool {
+	if route.tagGlob != "" {
+		tag, _ := event.Raw["tag"].(string)
+		matched, err := filepath.Match(route.tagGlob, tag)
+		if err != nil || !matched {
+			return false
+		}
+	}
+
+	for field, re := range route.fieldRegex {
+		value := fmt.Sprintf("%v", event.Raw[field])
+		if !re.MatchString(value) {
+			return false
+		}
+	}
+
+	return true
+}
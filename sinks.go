@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+var SMTPHost = os.Getenv("SMTP_HOST")
+var SMTPPort = os.Getenv("SMTP_PORT")
+var SMTPUser = os.Getenv("SMTP_USER")
+var SMTPPassword = os.Getenv("SMTP_PASSWORD")
+var SMTPFrom = os.Getenv("SMTP_FROM")
+var SMTPTo = os.Getenv("SMTP_TO")
+var WebhookURL = os.Getenv("WEBHOOK_URL")
+
+// Sink delivers an incoming event to a downstream destination.
+type Sink interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// namedSink pairs a Sink with a stable key identifying the destination it
+// delivers to, so the retry queue can track which destinations a given
+// event still needs delivering to instead of resending to every
+// destination on every retry.
+type namedSink struct {
+	Key  string
+	Sink Sink
+}
+
+// emailSink and webhookSink are the static sinks configured via
+// environment variables, computed once at startup. Telegram sinks are
+// built per-event by sinksForEvent since routing.go can fan one event out
+// to several chat targets.
+var emailSink = configuredEmailSink()
+var webhookSink = configuredWebhookSink()
+
+func configuredEmailSink() *EmailSink {
+	if SMTPHost == "" || SMTPTo == "" {
+		return nil
+	}
+
+	return &EmailSink{
+		Host:     SMTPHost,
+		Port:     SMTPPort,
+		User:     SMTPUser,
+		Password: SMTPPassword,
+		From:     SMTPFrom,
+		To:       SMTPTo,
+	}
+}
+
+func configuredWebhookSink() *HTTPWebhookSink {
+	if WebhookURL == "" {
+		return nil
+	}
+
+	return &HTTPWebhookSink{URL: WebhookURL}
+}
+
+// sinksForEvent returns the namedSinks that should receive event: one
+// telegramTargetSink per ChatTarget targetsForEvent resolves, plus the
+// static email/webhook sinks if configured.
+func sinksForEvent(event Event) []namedSink {
+	var sinks []namedSink
+
+	for _, target := range targetsForEvent(event) {
+		sinks = append(sinks, namedSink{
+			Key:  fmt.Sprintf("telegram:%s:%d", target.ChatID, target.ThreadID),
+			Sink: &telegramTargetSink{target: target},
+		})
+	}
+
+	if emailSink != nil {
+		sinks = append(sinks, namedSink{Key: "email", Sink: emailSink})
+	}
+
+	if webhookSink != nil {
+		sinks = append(sinks, namedSink{Key: "webhook", Sink: webhookSink})
+	}
+
+	return sinks
+}
+
+// filterSinksByKey keeps only the sinks whose Key appears in keys, used by
+// the retry queue to redeliver to exactly the destinations still pending
+// for a given entry.
+func filterSinksByKey(sinks []namedSink, keys []string) []namedSink {
+	keep := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keep[key] = true
+	}
+
+	var filtered []namedSink
+	for _, s := range sinks {
+		if keep[s.Key] {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered
+}
+
+// telegramTargetSink delivers to a single ChatTarget, formatting the event
+// with formatEvent and sending it as MarkdownV2 unless the target sets its
+// own parse mode.
+type telegramTargetSink struct {
+	target ChatTarget
+}
+
+func (s *telegramTargetSink) Send(ctx context.Context, event Event) error {
+	message, err := formatEvent(event)
+	if err != nil {
+		// A formatting error depends only on the event itself, so it will
+		// never succeed on retry.
+		return &permanentError{err: err}
+	}
+
+	parseMode := s.target.ParseMode
+	if parseMode == "" {
+		parseMode = "MarkdownV2"
+	}
+
+	return postTelegramMessage(s.target.ChatID, s.target.ThreadID, message, parseMode)
+}
+
+// EmailSink delivers the event as an HTML table over SMTP.
+type EmailSink struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	From     string
+	To       string
+}
+
+func (s *EmailSink) Send(ctx context.Context, event Event) error {
+	port := s.Port
+	if port == "" {
+		port = "587"
+	}
+
+	addr := fmt.Sprintf("%s:%s", s.Host, port)
+
+	var auth smtp.Auth
+	if s.User != "" {
+		auth = smtp.PlainAuth("", s.User, s.Password, s.Host)
+	}
+
+	var body strings.Builder
+	body.WriteString("Subject: New event received\r\n")
+	body.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+	body.WriteString(formatEventHTML(event))
+
+	if err := smtp.SendMail(addr, auth, s.From, []string{s.To}, []byte(body.String())); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			// Network-level failures (connection refused, timeout, ...)
+			// are worth retrying.
+			return err
+		}
+		// Everything else (auth rejected, malformed address, ...) depends
+		// only on configuration and won't succeed on retry.
+		return &permanentError{err: err}
+	}
+
+	return nil
+}
+
+// formatEventHTML renders event.Raw as an HTML table. Keys and values
+// come from the webhook payload, so both are escaped to avoid HTML/script
+// injection into the email client rendering the message.
+func formatEventHTML(event Event) string {
+	var table strings.Builder
+	table.WriteString("<table border=\"1\" cellpadding=\"4\" cellspacing=\"0\">\n")
+	for key, value := range event.Raw {
+		fmt.Fprintf(&table, "<tr><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(key), html.EscapeString(fmt.Sprintf("%v", value)))
+	}
+	table.WriteString("</table>\n")
+	return table.String()
+}
+
+// HTTPWebhookSink forwards the event as-is to an arbitrary HTTP endpoint.
+type HTTPWebhookSink struct {
+	URL string
+}
+
+func (s *HTTPWebhookSink) Send(ctx context.Context, event Event) error {
+	payloadBytes, err := json.Marshal(event.Raw)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// Network-level failure; worth retrying.
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		// The endpoint is up but erroring; may well recover by the next
+		// retry.
+		return fmt.Errorf("webhook sink %s failed, status code: %d", s.URL, resp.StatusCode)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// A 4xx means the request itself is wrong (bad URL, payload the
+		// endpoint rejects, ...) and retrying won't change that.
+		return &permanentError{err: fmt.Errorf("webhook sink %s failed, status code: %d", s.URL, resp.StatusCode)}
+	}
+
+	return nil
+}
+
+// dispatchToSinks delivers event to every sink concurrently and returns
+// the keys of the sinks whose failure is worth retrying, plus how many
+// sinks failed in total (permanent + pending). Sinks that fail with a
+// *permanentError are logged and dropped here rather than being returned
+// as pending, so the retry queue never resends to a destination that
+// can't possibly succeed. The returned error aggregates every failure
+// (permanent and transient) for logging and for backoffFor to inspect.
+func dispatchToSinks(ctx context.Context, sinks []namedSink, event Event) (pending []string, failed int, err error) {
+	type result struct {
+		key string
+		err error
+	}
+
+	results := make(chan result, len(sinks))
+	for _, sink := range sinks {
+		go func(s namedSink) {
+			results <- result{key: s.Key, err: s.Sink.Send(ctx, event)}
+		}(sink)
+	}
+
+	var failures []error
+	for range sinks {
+		r := <-results
+		if r.err == nil {
+			continue
+		}
+
+		failures = append(failures, r.err)
+		failed++
+
+		if isPermanent(r.err) {
+			log.Printf("Permanently failed to deliver to %s, dropping: %s\n", r.key, r.err.Error())
+			continue
+		}
+
+		pending = append(pending, r.key)
+	}
+
+	// errors.Join (rather than folding each error into one fmt.Errorf
+	// string) keeps failures unwrappable, so a *retryAfterError from a
+	// telegramTargetSink survives aggregation for backoffFor to find.
+	return pending, failed, errors.Join(failures...)
+}
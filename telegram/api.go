@@ -0,0 +1,77 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// API is a thin client for the Telegram Bot API endpoints this project
+// needs: sending messages and registering a webhook.
+type API struct {
+	Token      string
+	HTTPClient *http.Client
+}
+
+// NewAPI returns an API client for the given bot token, using
+// http.DefaultClient.
+func NewAPI(token string) *API {
+	return &API{Token: token, HTTPClient: http.DefaultClient}
+}
+
+func (a *API) endpoint(method string) string {
+	return fmt.Sprintf("https://api.telegram.org/bot%s/%s", a.Token, method)
+}
+
+// SendMessage posts a sendMessage call to the given chat. chatID may be a
+// numeric chat ID (int64) or an @channelname (string), as accepted by the
+// Telegram API. threadID, when non-zero, targets a specific forum topic
+// thread via message_thread_id.
+// See https://core.telegram.org/bots/api#sendmessage.
+func (a *API) SendMessage(chatID interface{}, threadID int, text, parseMode string) error {
+	payload := map[string]interface{}{
+		"chat_id":    chatID,
+		"text":       text,
+		"parse_mode": parseMode,
+	}
+	if threadID != 0 {
+		payload["message_thread_id"] = threadID
+	}
+
+	return a.post("sendMessage", payload)
+}
+
+// SetWebhook registers url as the bot's webhook endpoint, optionally
+// protected by a secret token Telegram will echo back in the
+// X-Telegram-Bot-Api-Secret-Token header.
+// See https://core.telegram.org/bots/api#setwebhook.
+func (a *API) SetWebhook(url, secretToken string) error {
+	payload := map[string]interface{}{
+		"url": url,
+	}
+	if secretToken != "" {
+		payload["secret_token"] = secretToken
+	}
+
+	return a.post("setWebhook", payload)
+}
+
+func (a *API) post(method string, payload map[string]interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.HTTPClient.Post(a.endpoint(method), "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram API %s failed, status code: %d", method, resp.StatusCode)
+	}
+
+	return nil
+}
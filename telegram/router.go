@@ -0,0 +1,50 @@
+package telegram
+
+import "strings"
+
+// HandlerFunc handles a bot command invoked from a Message. args holds the
+// text following the command, split on whitespace.
+type HandlerFunc func(msg *Message, args []string) error
+
+// CommandRouter dispatches incoming messages to registered command
+// handlers based on the leading "/command" token.
+type CommandRouter struct {
+	handlers map[string]HandlerFunc
+}
+
+// NewCommandRouter returns an empty CommandRouter ready for Handle calls.
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{handlers: make(map[string]HandlerFunc)}
+}
+
+// Handle registers fn to be called for messages starting with command,
+// e.g. Handle("/stats", ...).
+func (r *CommandRouter) Handle(command string, fn HandlerFunc) {
+	r.handlers[command] = fn
+}
+
+// Dispatch routes an incoming update to the matching command handler, if
+// any is registered and the update carries a text message. It returns
+// false when no handler matched so the caller can decide how to respond.
+func (r *CommandRouter) Dispatch(u *Update) (bool, error) {
+	if u.Message == nil || u.Message.Text == "" {
+		return false, nil
+	}
+
+	fields := strings.Fields(u.Message.Text)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	command := fields[0]
+	// Strip a "@botname" suffix, e.g. "/stats@my_bot".
+	if at := strings.IndexByte(command, '@'); at != -1 {
+		command = command[:at]
+	}
+
+	fn, ok := r.handlers[command]
+	if !ok {
+		return false, nil
+	}
+
+	return true, fn(u.Message, fields[1:])
+}
@@ -0,0 +1,33 @@
+// Package telegram contains the minimal subset of the Telegram Bot API
+// needed to receive updates and reply to bot commands.
+package telegram
+
+// Update is a Telegram Bot API update as delivered to a webhook.
+// See https://core.telegram.org/bots/api#update.
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message,omitempty"`
+}
+
+// Message is a Telegram Bot API message.
+// See https://core.telegram.org/bots/api#message.
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	From      *User  `json:"from,omitempty"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+// Chat is a Telegram Bot API chat.
+// See https://core.telegram.org/bots/api#chat.
+type Chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+// User is a Telegram Bot API user.
+// See https://core.telegram.org/bots/api#user.
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
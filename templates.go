@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var TemplateDir = os.Getenv("TEMPLATE_DIR")
+
+// markdownV2Escapes are the characters Telegram's MarkdownV2 parser
+// requires to be backslash-escaped outside of an entity.
+// See https://core.telegram.org/bots/api#markdownv2-style.
+const markdownV2Escapes = "_*[]()~`>#+-=|{}.!"
+
+// escapeMarkdownV2 escapes s for safe inclusion in a MarkdownV2 message,
+// fixing the previous bug where values containing "_" or "*" silently
+// broke Telegram's parser.
+func escapeMarkdownV2(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(markdownV2Escapes, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// formatMs renders a millisecond duration the way the waterfall output
+// has always shown it.
+func formatMs(ms float64) string {
+	return fmt.Sprintf("%.2f ms", ms)
+}
+
+var templateFuncs = template.FuncMap{
+	"formatMs":         formatMs,
+	"escapeMarkdownV2": escapeMarkdownV2,
+}
+
+// eventTemplates holds user-supplied text/template templates loaded from
+// TEMPLATE_DIR, one per event type (e.g. "navigation_timing.tmpl"),
+// loaded once at startup.
+var eventTemplates = loadEventTemplates(TemplateDir)
+
+func loadEventTemplates(dir string) map[string]*template.Template {
+	templates := make(map[string]*template.Template)
+	if dir == "" {
+		return templates
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		log.Printf("Error reading TEMPLATE_DIR %q: %s\n", dir, err.Error())
+		return templates
+	}
+
+	for _, path := range matches {
+		name := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+		if err != nil {
+			log.Printf("Error parsing template %q: %s\n", path, err.Error())
+			continue
+		}
+		templates[name] = tmpl
+	}
+
+	return templates
+}
+
+// formatEvent renders event to a MarkdownV2 message body. A user-supplied
+// template for event.Type takes precedence; otherwise a built-in
+// formatter is used, falling back to a generic key/value dump for
+// unrecognized types.
+func formatEvent(event Event) (string, error) {
+	if tmpl, ok := eventTemplates[event.Type]; ok {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, event); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+
+	if formatter, ok := builtinFormatters[event.Type]; ok {
+		return formatter(event)
+	}
+
+	return formatGenericEvent(event)
+}
+
+// builtinFormatters are the default, template-free formatters for the
+// event types this project knows about out of the box.
+var builtinFormatters = map[string]func(Event) (string, error){
+	"navigation_timing": formatNavigationTimingEvent,
+	"error":             formatGenericEvent,
+	"log":               formatGenericEvent,
+	"custom":            formatGenericEvent,
+}
+
+func formatNavigationTimingEvent(event Event) (string, error) {
+	if event.Timing == nil {
+		return "", fmt.Errorf("navigation_timing event missing decoded timing data")
+	}
+
+	t := event.Timing
+	calculatedTimes := map[string]float64{
+		"Redirect":          t.RedirectEnd - t.RedirectStart,
+		"AppCache":          t.DomainLookupStart - t.FetchStart,
+		"DNS Lookup":        t.DomainLookupEnd - t.DomainLookupStart,
+		"TCP Connection":    t.ConnectEnd - t.ConnectStart,
+		"SSL Handshake":     t.ConnectEnd - t.SecureConnectionStart,
+		"Request Sent":      t.ResponseStart - t.RequestStart,
+		"Response Received": t.ResponseEnd - t.ResponseStart,
+		"DOM Processing":    t.DomComplete - t.DomLoading,
+		"Load Event":        t.LoadEventEnd - t.LoadEventStart,
+		"DOMContentLoaded":  t.DomContentLoadedEventEnd - t.NavigationStart,
+		"Finish":            t.LoadEventEnd - t.NavigationStart,
+	}
+
+	var b strings.Builder
+	b.WriteString("*Waterfall Timing:*\n")
+	for key, value := range calculatedTimes {
+		if value > 0 {
+			fmt.Fprintf(&b, "*%s:* %s\n", escapeMarkdownV2(key), escapeMarkdownV2(formatMs(value)))
+		}
+	}
+
+	domLoadingTime := t.DomLoading - t.NavigationStart
+	fmt.Fprintf(&b, "\n*DOM Loading Time:* %s\n", escapeMarkdownV2(formatMs(domLoadingTime)))
+
+	return b.String(), nil
+}
+
+func formatGenericEvent(event Event) (string, error) {
+	var b strings.Builder
+	b.WriteString("*Received message:*\n\n")
+	for key, value := range event.Raw {
+		fmt.Fprintf(&b, "*%s:* %s\n", escapeMarkdownV2(key), escapeMarkdownV2(fmt.Sprintf("%v", value)))
+	}
+	return b.String(), nil
+}